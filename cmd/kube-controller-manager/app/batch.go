@@ -36,10 +36,18 @@ func startJobController(ctx context.Context, controllerContext ControllerContext
 	if !controllerContext.AvailableResources[schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}] {
 		return nil, false, nil
 	}
+	podInformer := controllerContext.InformerFactory.Core().V1().Pods()
+	jobInformer := controllerContext.InformerFactory.Batch().V1().Jobs()
+	jobClient := controllerContext.ClientBuilder.ClientOrDie("job-controller")
+
+	// Publish a Waiter backed by the same informers so callers elsewhere in this binary can
+	// block on a Job reaching a terminal state without standing up their own watches.
+	job.SetDefaultWaiter(job.NewWaiter(jobInformer, podInformer, jobClient))
+
 	go job.NewController(
-		controllerContext.InformerFactory.Core().V1().Pods(),
-		controllerContext.InformerFactory.Batch().V1().Jobs(),
-		controllerContext.ClientBuilder.ClientOrDie("job-controller"),
+		podInformer,
+		jobInformer,
+		jobClient,
 	).Run(int(controllerContext.ComponentConfig.JobController.ConcurrentJobSyncs), controllerContext.Stop)
 	return nil, true, nil
 }
@@ -0,0 +1,314 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	batchv1informers "k8s.io/client-go/informers/batch/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	batchv1listers "k8s.io/client-go/listers/batch/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WaitOptions configures how a Waiter blocks for a Job to reach a condition.
+type WaitOptions struct {
+	// Timeout bounds how long WaitForCompletion or WaitForCondition will block before
+	// giving up and returning a *TimeoutError. Zero means wait forever (bounded only by
+	// the passed-in context).
+	Timeout time.Duration
+
+	// BackoffLimit bounds how many consecutive errors reading the Job (from the informer
+	// cache, or from the API server on a cache miss) the waiter will tolerate before giving
+	// up. Zero means retry indefinitely.
+	BackoffLimit int
+
+	// RequireAllPodsReady, when true, additionally waits until the Job's status.active
+	// is zero and every Pod selected by the Job has reached PodSucceeded or PodFailed.
+	// This mirrors the pod readiness semantics Helm's resource readiness checker uses
+	// (containers ready, no restart loops) for callers who don't trust the Job status
+	// alone to reflect that all of its Pods have actually stopped running.
+	RequireAllPodsReady bool
+}
+
+// TerminalError is returned when a Job reaches JobFailed=True while a Waiter is blocked on
+// it reaching some other condition, so callers don't mistake a failed Job for a timeout.
+type TerminalError struct {
+	Namespace string
+	Name      string
+	Condition batchv1.JobCondition
+}
+
+func (e *TerminalError) Error() string {
+	return fmt.Sprintf("job %s/%s failed: %s: %s", e.Namespace, e.Name, e.Condition.Reason, e.Condition.Message)
+}
+
+// PendingPod summarizes a Pod that had not reached a terminal phase when a Waiter gave up.
+type PendingPod struct {
+	Name          string
+	Phase         corev1.PodPhase
+	LastCondition *corev1.PodCondition
+}
+
+// TimeoutError is returned when a Waiter gives up before the Job reached the requested
+// condition. It summarizes the Job's still-pending Pods so callers can surface actionable
+// diagnostics instead of a bare "timed out" message.
+type TimeoutError struct {
+	Namespace string
+	Name      string
+	Pending   []PendingPod
+	Cause     error
+}
+
+func (e *TimeoutError) Error() string {
+	if len(e.Pending) == 0 {
+		return fmt.Sprintf("timed out waiting for job %s/%s: %v", e.Namespace, e.Name, e.Cause)
+	}
+	msg := fmt.Sprintf("timed out waiting for job %s/%s, %d pod(s) still pending:", e.Namespace, e.Name, len(e.Pending))
+	for _, pod := range e.Pending {
+		condDesc := "no status reported"
+		if pod.LastCondition != nil {
+			condDesc = fmt.Sprintf("%s=%s (%s)", pod.LastCondition.Type, pod.LastCondition.Status, pod.LastCondition.Reason)
+		}
+		msg += fmt.Sprintf("\n  %s: phase=%s, %s", pod.Name, pod.Phase, condDesc)
+	}
+	return msg
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Cause
+}
+
+// Waiter blocks callers until a Job reaches a terminal state. It's backed by the same
+// JobInformer and PodInformer caches the Job controller already maintains, so waiting
+// doesn't add extra API watches.
+type Waiter struct {
+	jobLister batchv1listers.JobLister
+	jobSynced cache.InformerSynced
+
+	podLister corev1listers.PodLister
+	podSynced cache.InformerSynced
+
+	client kubernetes.Interface
+}
+
+// NewWaiter returns a Waiter backed by the given informers' caches. The informers are
+// expected to already be running (e.g. started alongside startJobController); NewWaiter
+// does not start them itself.
+func NewWaiter(jobInformer batchv1informers.JobInformer, podInformer coreinformers.PodInformer, client kubernetes.Interface) *Waiter {
+	return &Waiter{
+		jobLister: jobInformer.Lister(),
+		jobSynced: jobInformer.Informer().HasSynced,
+		podLister: podInformer.Lister(),
+		podSynced: podInformer.Informer().HasSynced,
+		client:    client,
+	}
+}
+
+// defaultWaiterMu guards defaultWaiter.
+var defaultWaiterMu sync.RWMutex
+var defaultWaiter *Waiter
+
+// SetDefaultWaiter records w as the package's default Waiter, so that callers elsewhere in
+// the binary (e.g. an admission webhook or a CLI subcommand) can block on a Job without
+// needing their own copy of the informers startJobController already maintains. It's
+// expected to be called once, from startJobController's setup, before DefaultWaiter is used.
+func SetDefaultWaiter(w *Waiter) {
+	defaultWaiterMu.Lock()
+	defer defaultWaiterMu.Unlock()
+	defaultWaiter = w
+}
+
+// DefaultWaiter returns the Waiter most recently passed to SetDefaultWaiter, or nil if none
+// has been set yet.
+func DefaultWaiter() *Waiter {
+	defaultWaiterMu.RLock()
+	defer defaultWaiterMu.RUnlock()
+	return defaultWaiter
+}
+
+// WaitForCompletion blocks until the named Job's status.conditions contains Complete=True,
+// returning its final status. It is shorthand for WaitForCondition(ctx, ns, name,
+// batchv1.JobComplete, opts).
+func (w *Waiter) WaitForCompletion(ctx context.Context, namespace, name string, opts WaitOptions) (*batchv1.JobStatus, error) {
+	return w.WaitForCondition(ctx, namespace, name, batchv1.JobComplete, opts)
+}
+
+// WaitForCondition blocks until the named Job's status.conditions contains cond=True. If the
+// Job instead reaches JobFailed=True first, it returns the Job's final status alongside a
+// *TerminalError. If opts.Timeout elapses first, it returns a *TimeoutError summarizing the
+// Job's pending Pods.
+func (w *Waiter) WaitForCondition(ctx context.Context, namespace, name string, cond batchv1.JobConditionType, opts WaitOptions) (*batchv1.JobStatus, error) {
+	waitCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	if !cache.WaitForCacheSync(waitCtx.Done(), w.jobSynced, w.podSynced) {
+		return nil, &TimeoutError{Namespace: namespace, Name: name, Cause: fmt.Errorf("informer caches did not sync")}
+	}
+
+	var lastJob *batchv1.Job
+	var consecutiveErrors int
+
+	pollErr := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		j, err := w.jobLister.Jobs(namespace).Get(name)
+		switch {
+		case apierrors.IsNotFound(err):
+			// The informer cache can lag behind the API server, most visibly right after
+			// the Job is created; fall back to a live read before concluding it's really
+			// gone rather than reporting a false "not found" that makes the caller give up.
+			j, err = w.client.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			if err != nil {
+				consecutiveErrors++
+				if opts.BackoffLimit > 0 && consecutiveErrors > opts.BackoffLimit {
+					return false, err
+				}
+				return false, nil
+			}
+		case err != nil:
+			consecutiveErrors++
+			if opts.BackoffLimit > 0 && consecutiveErrors > opts.BackoffLimit {
+				return false, err
+			}
+			return false, nil
+		}
+		consecutiveErrors = 0
+		lastJob = j
+
+		if cond != batchv1.JobFailed {
+			if failedCond := findJobCondition(j, batchv1.JobFailed); failedCond != nil {
+				return false, &TerminalError{Namespace: namespace, Name: name, Condition: *failedCond}
+			}
+		}
+
+		if findJobCondition(j, cond) == nil {
+			return false, nil
+		}
+
+		if opts.RequireAllPodsReady {
+			ready, err := w.allPodsTerminal(j)
+			if err != nil || !ready {
+				return false, err
+			}
+		}
+
+		return true, nil
+	}, waitCtx.Done())
+
+	if pollErr == nil {
+		return &lastJob.Status, nil
+	}
+
+	var termErr *TerminalError
+	if errors.As(pollErr, &termErr) {
+		if lastJob != nil {
+			return &lastJob.Status, termErr
+		}
+		return nil, termErr
+	}
+
+	var status *batchv1.JobStatus
+	if lastJob != nil {
+		status = &lastJob.Status
+	}
+	return status, &TimeoutError{Namespace: namespace, Name: name, Pending: w.pendingPods(namespace, lastJob), Cause: pollErr}
+}
+
+// allPodsTerminal reports whether the Job has no active Pods and every Pod it selects has
+// reached PodSucceeded or PodFailed.
+func (w *Waiter) allPodsTerminal(j *batchv1.Job) (bool, error) {
+	if j.Status.Active != 0 {
+		return false, nil
+	}
+
+	pods, err := w.selectedPods(j)
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// pendingPods returns a diagnostic summary of the Pods selected by j that have not yet
+// reached a terminal phase. It swallows errors, since it's only used to enrich a timeout
+// error that's already on its way back to the caller.
+func (w *Waiter) pendingPods(namespace string, j *batchv1.Job) []PendingPod {
+	if j == nil {
+		return nil
+	}
+	pods, err := w.selectedPods(j)
+	if err != nil {
+		return nil
+	}
+
+	var pending []PendingPod
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		p := PendingPod{Name: pod.Name, Phase: pod.Status.Phase}
+		if n := len(pod.Status.Conditions); n > 0 {
+			p.LastCondition = &pod.Status.Conditions[n-1]
+		}
+		pending = append(pending, p)
+	}
+	return pending
+}
+
+func (w *Waiter) selectedPods(j *batchv1.Job) ([]*corev1.Pod, error) {
+	if j.Spec.Selector == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(j.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return w.podLister.Pods(j.Namespace).List(selector)
+}
+
+// findJobCondition returns a pointer to j's condition of the given type with status True,
+// or nil if no such condition is present.
+func findJobCondition(j *batchv1.Job, condType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range j.Status.Conditions {
+		c := &j.Status.Conditions[i]
+		if c.Type == condType && c.Status == corev1.ConditionTrue {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestWaiter(objs ...interface{}) (*Waiter, func()) {
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	jobInformer := factory.Batch().V1().Jobs()
+	podInformer := factory.Core().V1().Pods()
+
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *batchv1.Job:
+			jobInformer.Informer().GetStore().Add(o)
+		case *corev1.Pod:
+			podInformer.Informer().GetStore().Add(o)
+		}
+	}
+
+	// The factory is deliberately not started: a running informer's initial List from the
+	// empty fake clientset would remove the objects seeded into its store above.
+	w := NewWaiter(jobInformer, podInformer, client)
+	w.jobSynced = func() bool { return true }
+	w.podSynced = func() bool { return true }
+
+	return w, func() {}
+}
+
+func TestWaitForCompletionSucceeds(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-a", Namespace: "default"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	w, stop := newTestWaiter(job)
+	defer stop()
+
+	status, err := w.WaitForCompletion(context.Background(), "default", "job-a", WaitOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status == nil || len(status.Conditions) != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestWaitForCompletionTerminalError(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-b", Namespace: "default"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+			},
+		},
+	}
+	w, stop := newTestWaiter(job)
+	defer stop()
+
+	_, err := w.WaitForCompletion(context.Background(), "default", "job-b", WaitOptions{Timeout: time.Second})
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	termErr, ok := err.(*TerminalError)
+	if !ok {
+		t.Fatalf("expected *TerminalError, got %T: %v", err, err)
+	}
+	if termErr.Condition.Reason != "BackoffLimitExceeded" {
+		t.Fatalf("unexpected terminal error: %+v", termErr)
+	}
+}
+
+func TestWaitForCompletionTimeout(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-c", Namespace: "default"},
+	}
+	w, stop := newTestWaiter(job)
+	defer stop()
+
+	_, err := w.WaitForCompletion(context.Background(), "default", "job-c", WaitOptions{Timeout: 10 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected a timeout error, got nil")
+	}
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("expected *TimeoutError, got %T: %v", err, err)
+	}
+}
+
+func TestWaitForCompletionRequiresAllPodsReady(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": "job-d"}}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-d", Namespace: "default"},
+		Spec:       batchv1.JobSpec{Selector: selector},
+		Status: batchv1.JobStatus{
+			Active: 0,
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	runningPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-d-xyz", Namespace: "default", Labels: map[string]string{"job-name": "job-d"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	w, stop := newTestWaiter(job, runningPod)
+	defer stop()
+
+	_, err := w.WaitForCompletion(context.Background(), "default", "job-d", WaitOptions{
+		Timeout:             10 * time.Millisecond,
+		RequireAllPodsReady: true,
+	})
+	timeoutErr, ok := err.(*TimeoutError)
+	if !ok {
+		t.Fatalf("expected *TimeoutError, got %T: %v", err, err)
+	}
+	if len(timeoutErr.Pending) != 1 || timeoutErr.Pending[0].Name != "job-d-xyz" {
+		t.Fatalf("expected job-d-xyz to be reported pending, got %+v", timeoutErr.Pending)
+	}
+}
+
+func TestWaitForCompletionFallsBackToLiveReadOnCacheMiss(t *testing.T) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-e", Namespace: "default"},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+	// newTestWaiter only seeds the informer stores; add job to the fake clientset directly
+	// (not the lister's store) to simulate the lister's cache lagging behind a Job that
+	// already exists on the API server.
+	w, stop := newTestWaiter()
+	defer stop()
+	if _, err := w.client.BatchV1().Jobs("default").Create(job); err != nil {
+		t.Fatalf("unable to seed fake clientset: %v", err)
+	}
+
+	status, err := w.WaitForCompletion(context.Background(), "default", "job-e", WaitOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status == nil || len(status.Conditions) != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestDefaultWaiter(t *testing.T) {
+	w, stop := newTestWaiter()
+	defer stop()
+
+	SetDefaultWaiter(w)
+	defer SetDefaultWaiter(nil)
+
+	if DefaultWaiter() != w {
+		t.Fatal("expected DefaultWaiter to return the waiter passed to SetDefaultWaiter")
+	}
+}
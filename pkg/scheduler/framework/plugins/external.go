@@ -0,0 +1,187 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"plugin"
+
+	"sigs.k8s.io/yaml"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+// pluginsSymbolName is the symbol an external plugin .so is expected to export: a
+// map[string]framework.PluginFactory, keyed by the name each factory should be registered
+// under. The indirection through a map is necessary because Go's plugin package can only
+// look up a symbol it's told to look for by name; it has no way to enumerate what a .so
+// exports.
+const pluginsSymbolName = "Plugins"
+
+// ExternalPluginConfigEntry describes a single out-of-tree plugin to load via
+// WithExternalPluginConfig. Name must match one of the names the plugin's .so exports under
+// pluginsSymbolName; Args is marshaled into the *runtime.Unknown passed to that plugin's
+// factory, mirroring the noderesources.FitName decoding path in registry.go.
+type ExternalPluginConfigEntry struct {
+	Name string          `json:"name"`
+	Path string          `json:"path"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// WithExternalPluginConfig points LoadExternalPlugins at a YAML file listing
+// ExternalPluginConfigEntry entries, each naming a plugin to load and the default args to
+// hand its factory.
+func WithExternalPluginConfig(path string) Option {
+	return func(o *registryOptions) {
+		o.externalPluginConfig = path
+	}
+}
+
+// WithExternalPluginsOverride allows external plugins returned by LoadExternalPlugins to
+// replace an in-tree plugin registered under the same name when passed to MergeRegistries.
+// Without it, MergeRegistries always keeps the in-tree entry.
+func WithExternalPluginsOverride() Option {
+	return func(o *registryOptions) {
+		o.overrideInTree = true
+	}
+}
+
+// LoadExternalPlugins dlopen's the Go plugin files at paths, plus any additionally declared
+// via WithExternalPluginConfig, and registers the framework.PluginFactory symbols they
+// export. It does not merge the result with the in-tree registry; call MergeRegistries with
+// the two registries once both are built.
+func LoadExternalPlugins(paths []string, opts ...Option) (framework.Registry, error) {
+	var options registryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	registry := framework.Registry{}
+	for _, path := range paths {
+		if err := loadPluginFile(path, registry); err != nil {
+			return nil, fmt.Errorf("loading external plugin %q: %v", path, err)
+		}
+	}
+
+	if options.externalPluginConfig != "" {
+		entries, err := readExternalPluginConfig(options.externalPluginConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if err := loadPluginFile(entry.Path, registry); err != nil {
+				return nil, fmt.Errorf("loading external plugin %q from config: %v", entry.Name, err)
+			}
+			factory, ok := registry[entry.Name]
+			if !ok {
+				return nil, fmt.Errorf("external plugin config declared %q, but %q does not export a factory by that name", entry.Name, entry.Path)
+			}
+			if len(entry.Args) > 0 {
+				registry[entry.Name] = withDefaultArgs(factory, entry.Args)
+			}
+		}
+	}
+
+	return registry, nil
+}
+
+// MergeRegistries combines inTree and external into a single Registry. An external plugin
+// is skipped whenever the in-tree registry already registered the same name, unless the
+// caller opted in via WithExternalPluginsOverride.
+func MergeRegistries(inTree, external framework.Registry, opts ...Option) framework.Registry {
+	var options registryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	merged := framework.Registry{}
+	for name, factory := range inTree {
+		merged[name] = factory
+	}
+	for name, factory := range external {
+		if _, exists := merged[name]; exists && !options.overrideInTree {
+			continue
+		}
+		merged[name] = factory
+	}
+	return merged
+}
+
+// withDefaultArgs wraps factory so that defaultArgs are used whenever the scheduler profile
+// doesn't supply its own args for this plugin. Args supplied by the profile replace
+// defaultArgs entirely; unlike registry.go's noderesources.FitName entry, the two are not
+// merged.
+func withDefaultArgs(factory framework.PluginFactory, defaultArgs json.RawMessage) framework.PluginFactory {
+	return func(plArgs *runtime.Unknown, handle framework.FrameworkHandle) (framework.Plugin, error) {
+		args := defaultArgs
+		if plArgs != nil && len(plArgs.Raw) > 0 {
+			args = plArgs.Raw
+		}
+		return factory(&runtime.Unknown{Raw: args}, handle)
+	}
+}
+
+// loadPluginFile opens the .so at path and registers every framework.PluginFactory it
+// exports under pluginsSymbolName into registry. A .so built against a different version of
+// the framework package is rejected by plugin.Open with an error, and a symbol of any other
+// type fails the type check below. Any panic raised while loading is recovered here and
+// turned into a safety-check error instead of crashing the scheduler.
+func loadPluginFile(path string, registry framework.Registry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("plugin %q does not match the current framework.Plugin interface: %v", path, r)
+		}
+	}()
+
+	p, openErr := plugin.Open(path)
+	if openErr != nil {
+		return fmt.Errorf("opening plugin file: %v", openErr)
+	}
+
+	sym, lookupErr := p.Lookup(pluginsSymbolName)
+	if lookupErr != nil {
+		return fmt.Errorf("plugin does not export a %q symbol: %v", pluginsSymbolName, lookupErr)
+	}
+
+	factories, ok := sym.(*map[string]framework.PluginFactory)
+	if !ok {
+		return fmt.Errorf("plugin's %q symbol has type %T, expected *map[string]framework.PluginFactory", pluginsSymbolName, sym)
+	}
+
+	for name, factory := range *factories {
+		if factory == nil {
+			return fmt.Errorf("plugin registered a nil factory for %q", name)
+		}
+		registry[name] = factory
+	}
+	return nil
+}
+
+func readExternalPluginConfig(path string) ([]ExternalPluginConfigEntry, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading external plugin config %q: %v", path, err)
+	}
+	var entries []ExternalPluginConfigEntry
+	if err := yaml.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("parsing external plugin config %q: %v", path, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugins
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+
+	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	frameworkv1alpha1 "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+func TestMergeRegistries(t *testing.T) {
+	var inTreeCalled, externalCalled bool
+	inTree := frameworkv1alpha1.Registry{
+		"shared": func(*apimachineryruntime.Unknown, frameworkv1alpha1.FrameworkHandle) (frameworkv1alpha1.Plugin, error) {
+			inTreeCalled = true
+			return nil, nil
+		},
+	}
+	external := frameworkv1alpha1.Registry{
+		"shared": func(*apimachineryruntime.Unknown, frameworkv1alpha1.FrameworkHandle) (frameworkv1alpha1.Plugin, error) {
+			externalCalled = true
+			return nil, nil
+		},
+		"extra": func(*apimachineryruntime.Unknown, frameworkv1alpha1.FrameworkHandle) (frameworkv1alpha1.Plugin, error) {
+			return nil, nil
+		},
+	}
+
+	merged := MergeRegistries(inTree, external)
+	if _, err := merged["shared"](nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !inTreeCalled || externalCalled {
+		t.Fatal("expected the in-tree factory to win by default")
+	}
+	if _, ok := merged["extra"]; !ok {
+		t.Fatal("expected the external-only plugin to be present")
+	}
+
+	mergedOverride := MergeRegistries(inTree, external, WithExternalPluginsOverride())
+	inTreeCalled, externalCalled = false, false
+	if _, err := mergedOverride["shared"](nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inTreeCalled || !externalCalled {
+		t.Fatal("expected the external factory to win with WithExternalPluginsOverride")
+	}
+}
+
+const samplePluginSource = `
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	framework "k8s.io/kubernetes/pkg/scheduler/framework/v1alpha1"
+)
+
+type samplePlugin struct{}
+
+func (samplePlugin) Name() string { return "sample" }
+
+func New(_ *runtime.Unknown, _ framework.FrameworkHandle) (framework.Plugin, error) {
+	return samplePlugin{}, nil
+}
+
+var Plugins = map[string]framework.PluginFactory{
+	"sample": New,
+}
+`
+
+// buildSamplePlugin compiles samplePluginSource into a .so under dir, skipping the test if
+// the local Go toolchain can't build plugins (e.g. not linux, or no network access to fetch
+// this module's dependency graph).
+func buildSamplePlugin(t *testing.T, dir string) string {
+	t.Helper()
+	if goruntime.GOOS != "linux" {
+		t.Skip("Go plugins are only exercised on linux in CI")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go toolchain available to build the sample plugin")
+	}
+
+	srcPath := filepath.Join(dir, "sample_plugin.go")
+	if err := ioutil.WriteFile(srcPath, []byte(samplePluginSource), 0644); err != nil {
+		t.Fatalf("writing sample plugin source: %v", err)
+	}
+
+	soPath := filepath.Join(dir, "sample_plugin.so")
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", soPath, srcPath)
+	cmd.Env = os.Environ()
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("could not build sample plugin, skipping: %v\n%s", err, out)
+	}
+	return soPath
+}
+
+func TestLoadExternalPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external-plugins")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	soPath := buildSamplePlugin(t, dir)
+
+	registry, err := LoadExternalPlugins([]string{soPath})
+	if err != nil {
+		t.Fatalf("LoadExternalPlugins: %v", err)
+	}
+
+	factory, ok := registry["sample"]
+	if !ok {
+		t.Fatal("expected the sample plugin to be registered")
+	}
+	pl, err := factory(&apimachineryruntime.Unknown{}, nil)
+	if err != nil {
+		t.Fatalf("factory returned an error: %v", err)
+	}
+	if pl.Name() != "sample" {
+		t.Fatalf("unexpected plugin name: %s", pl.Name())
+	}
+}
@@ -43,7 +43,9 @@ import (
 )
 
 type registryOptions struct {
-	ignoredResources []string
+	ignoredResources     []string
+	externalPluginConfig string
+	overrideInTree       bool
 }
 
 // Option configures a registry
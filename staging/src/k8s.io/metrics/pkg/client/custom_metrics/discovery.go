@@ -21,34 +21,24 @@ import (
 	"sync"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 
 	cmint "k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/client/custom_metrics/scheme"
 )
 
-var (
-	// metricVersionsToGV is the map of string group-versions
-	// accepted by the converter to group-version objects (so
-	// we don't have to re-parse)
-	metricVersionsToGV map[string]schema.GroupVersion
-)
-
-func init() {
-	metricVersionsToGV = make(map[string]schema.GroupVersion)
-	for _, ver := range MetricVersions {
-		metricVersionsToGV[ver.String()] = ver
-	}
-}
-
 func NewAvailableAPIsGetter(client discovery.DiscoveryInterface) AvailableAPIsGetter {
 	return &apiVersionsFromDiscovery{
 		client: client,
+		scheme: scheme.Scheme,
 	}
 }
 
 type apiVersionsFromDiscovery struct {
 	client discovery.DiscoveryInterface
+	scheme *runtime.Scheme
 
 	// just cache the group directly since the discovery interface doesn't yet allow
 	// asking for a single API group's versions.
@@ -83,12 +73,19 @@ func (d *apiVersionsFromDiscovery) fetchVersions() (*metav1.APIGroup, error) {
 }
 
 func (d *apiVersionsFromDiscovery) chooseVersion(apiGroup *metav1.APIGroup) (schema.GroupVersion, error) {
+	// Consult the scheme for the versions it knows about, in priority order, instead of a
+	// hard-coded list, so versions registered by out-of-tree adapters are picked up here too.
+	knownVersions := make(map[string]schema.GroupVersion)
+	for _, gv := range d.scheme.PrioritizedVersionsForGroup(cmint.GroupName) {
+		knownVersions[gv.String()] = gv
+	}
+
 	var preferredVersion *schema.GroupVersion
-	if gv, present := metricVersionsToGV[apiGroup.PreferredVersion.GroupVersion]; present && len(apiGroup.PreferredVersion.GroupVersion) != 0 {
+	if gv, present := knownVersions[apiGroup.PreferredVersion.GroupVersion]; present && len(apiGroup.PreferredVersion.GroupVersion) != 0 {
 		preferredVersion = &gv
 	} else {
 		for _, version := range apiGroup.Versions {
-			if gv, present := metricVersionsToGV[version.GroupVersion]; present {
+			if gv, present := knownVersions[version.GroupVersion]; present {
 				preferredVersion = &gv
 				break
 			}
@@ -18,6 +18,7 @@ package custom_metrics
 
 import (
 	"fmt"
+	"sync"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -40,20 +41,8 @@ var (
 		cmv1beta1.SchemeGroupVersion,
 		cmint.SchemeGroupVersion,
 	}
-
-	// metricVersionsToGV is the map of string group-versions
-	// accepted by the converter to group-version objects (so
-	// we don't have to re-parse)
-	metricVersionsToGV map[string]schema.GroupVersion
 )
 
-func init() {
-	metricVersionsToGV = make(map[string]schema.GroupVersion)
-	for _, ver := range MetricVersions {
-		metricVersionsToGV[ver.String()] = ver
-	}
-}
-
 type AvailableMetricsAPIFunc func() (*metav1.APIGroup, error)
 
 type APIVersionsFromDiscovery struct {
@@ -110,12 +99,52 @@ type MetricConverter struct {
 	codecs            serializer.CodecFactory
 	internalVersioner runtime.GroupVersioner
 	metricsVersions   AvailableMetricsAPIFunc
+
+	hooksMu         sync.RWMutex
+	conversionHooks map[conversionKey][]ConversionFunc
+}
+
+// ConversionFunc is invoked by UnsafeConvertToVersionVia after it round-trips obj through the
+// internal version, so that callers can preserve or adapt fields that don't have a generated
+// conversion between two external custom metrics API versions (for example, a selector field
+// that only exists on one side of the conversion).
+type ConversionFunc func(in, out runtime.Object) error
+
+// conversionKey identifies a registered conversion hook by the external versions it runs between.
+type conversionKey struct {
+	from schema.GroupVersion
+	to   schema.GroupVersion
+}
+
+// ConversionHookError wraps an error returned by a registered ConversionFunc, so that callers
+// can distinguish a failure in a hook from the generic scheme conversion errors returned
+// elsewhere in UnsafeConvertToVersionVia.
+type ConversionHookError struct {
+	From schema.GroupVersion
+	To   schema.GroupVersion
+	Err  error
+}
+
+func (e *ConversionHookError) Error() string {
+	return fmt.Sprintf("conversion hook from %s to %s failed: %v", e.From, e.To, e.Err)
+}
+
+func (e *ConversionHookError) Unwrap() error {
+	return e.Err
 }
 
 func NewMetricConverter(apiVersions AvailableMetricsAPIFunc) *MetricConverter {
+	return NewMetricConverterWithScheme(scheme.Scheme, apiVersions)
+}
+
+// NewMetricConverterWithScheme is like NewMetricConverter, but allows callers to supply their
+// own scheme. This is useful for out-of-tree adapters that register additional custom metrics
+// API versions (e.g. a future custom.metrics.k8s.io version) into their own scheme and want the
+// converter to automatically prefer them, without needing changes to this package.
+func NewMetricConverterWithScheme(s *runtime.Scheme, apiVersions AvailableMetricsAPIFunc) *MetricConverter {
 	return &MetricConverter{
-		scheme:          scheme.Scheme,
-		codecs:          serializer.NewCodecFactory(scheme.Scheme),
+		scheme:          s,
+		codecs:          serializer.NewCodecFactory(s),
 		metricsVersions: apiVersions,
 		internalVersioner: runtime.NewMultiGroupVersioner(
 			scheme.SchemeGroupVersion,
@@ -135,15 +164,62 @@ func (c *MetricConverter) Codecs() serializer.CodecFactory {
 	return c.codecs
 }
 
+// RegisterConversionFunc registers fn to run whenever UnsafeConvertToVersionVia converts an
+// object from the from version to the to version, in addition to the generic field-by-field
+// conversion already performed via the internal version. This plays the same role for the
+// custom metrics versions that CRD conversion webhooks play for CRs during version migrations:
+// it lets adapters that expose version-specific fields (e.g. a newer Metric.Selector with no
+// equivalent on an older version) preserve that data on down-conversion instead of silently
+// dropping it. Hooks for the same (from, to) pair run in registration order.
+func (c *MetricConverter) RegisterConversionFunc(from, to schema.GroupVersion, fn ConversionFunc) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+
+	if c.conversionHooks == nil {
+		c.conversionHooks = make(map[conversionKey][]ConversionFunc)
+	}
+	key := conversionKey{from: from, to: to}
+	c.conversionHooks[key] = append(c.conversionHooks[key], fn)
+}
+
+// runConversionHooks runs any hooks registered for the from/to pair. It's a no-op if from and
+// to are equal, or if obj didn't carry a recognizable source GroupVersionKind (e.g. an
+// internal object that was never decoded from a versioned wire format).
+func (c *MetricConverter) runConversionHooks(from, to schema.GroupVersion, in, out runtime.Object) error {
+	if from == to || (schema.GroupVersion{}) == from {
+		return nil
+	}
+
+	c.hooksMu.RLock()
+	hooks := c.conversionHooks[conversionKey{from: from, to: to}]
+	c.hooksMu.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(in, out); err != nil {
+			return &ConversionHookError{From: from, To: to, Err: err}
+		}
+	}
+	return nil
+}
+
 func (c *MetricConverter) negotiatePreferredVersion(apiGroup *metav1.APIGroup) (*schema.GroupVersion, error) {
+	// Ask the scheme for the versions it knows about, in priority order, rather than
+	// consulting a hard-coded list. This lets out-of-tree adapters that register an
+	// additional custom metrics API version into their own scheme be picked up here
+	// without requiring changes to this package.
+	knownVersions := make(map[string]schema.GroupVersion)
+	for _, gv := range c.scheme.PrioritizedVersionsForGroup(cmint.GroupName) {
+		knownVersions[gv.String()] = gv
+	}
+
 	// Check if a preferred version is set in the APIGroup
 	// If not, we need to compare all of the available versions to ours to find a match.
 	var preferredVersion *schema.GroupVersion
-	if gv, present := metricVersionsToGV[apiGroup.PreferredVersion.GroupVersion]; present && len(apiGroup.PreferredVersion.GroupVersion) != 0 {
+	if gv, present := knownVersions[apiGroup.PreferredVersion.GroupVersion]; present && len(apiGroup.PreferredVersion.GroupVersion) != 0 {
 		preferredVersion = &gv
 	} else {
 		for _, version := range apiGroup.Versions {
-			if gv, present := metricVersionsToGV[version.GroupVersion]; present {
+			if gv, present := knownVersions[version.GroupVersion]; present {
 				preferredVersion = &gv
 				break
 			}
@@ -198,6 +274,8 @@ func (c *MetricConverter) ConvertResultToVersion(res rest.Result, gv schema.Grou
 // unsafeConvertToVersionVia is like Scheme.UnsafeConvertToVersion, but it does so via an internal version first.
 // We use it here to work with the v1beta2 client internally, while preserving backwards compatibility for existing custom metrics adapters
 func (c *MetricConverter) UnsafeConvertToVersionVia(obj runtime.Object, externalVersion schema.GroupVersion) (runtime.Object, error) {
+	sourceVersion := obj.GetObjectKind().GroupVersionKind().GroupVersion()
+
 	objInt, err := c.scheme.UnsafeConvertToVersion(obj, schema.GroupVersion{Group: externalVersion.Group, Version: runtime.APIVersionInternal})
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert the given object to the internal version: %v", err)
@@ -208,5 +286,9 @@ func (c *MetricConverter) UnsafeConvertToVersionVia(obj runtime.Object, external
 		return nil, fmt.Errorf("failed to convert the given object back to the external version: %v", err)
 	}
 
+	if err := c.runConversionHooks(sourceVersion, externalVersion, obj, objExt); err != nil {
+		return nil, err
+	}
+
 	return objExt, err
 }
@@ -17,6 +17,8 @@ limitations under the License.
 package custom_metrics
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -94,3 +96,96 @@ func TestMetricConverter(t *testing.T) {
 		})
 	}
 }
+
+// TestMetricConverterWithScheme verifies that a version registered into a caller-supplied
+// scheme (rather than the package default) is honored by version negotiation, so that
+// out-of-tree adapters can register additional custom metrics API versions without
+// requiring changes to this package. It builds a scheme the package default knows nothing
+// about, registers a fake extra version into it, and confirms that version (not anything
+// the default scheme knows) is the one negotiation prefers.
+func TestMetricConverterWithScheme(t *testing.T) {
+	customScheme := runtime.NewScheme()
+	require.NoError(t, cmint.AddToScheme(customScheme))
+	require.NoError(t, cmv1beta1.AddToScheme(customScheme))
+	require.NoError(t, cmv1beta2.AddToScheme(customScheme))
+
+	// Register a fake out-of-tree version that reuses cmv1beta2's Go type (conversions are
+	// registered by Go type, not by GroupVersionKind, so this is enough to make the fake
+	// version round-trip) under a GroupVersion the package default scheme has never heard of.
+	fakeVersion := schema.GroupVersion{Group: cmint.SchemeGroupVersion.Group, Version: "v2fake"}
+	customScheme.AddKnownTypeWithName(fakeVersion.WithKind("MetricListOptions"), &cmv1beta2.MetricListOptions{})
+	customScheme.SetVersionPriority(fakeVersion, cmv1beta2.SchemeGroupVersion, cmv1beta1.SchemeGroupVersion)
+
+	group := makeAPIGroup(fakeVersion.Version, fakeVersion.Version, "v1beta2", "v1beta1")
+	metricConverter := NewMetricConverterWithScheme(customScheme, func() (*metav1.APIGroup, error) { return group, nil })
+
+	opts := &cmint.MetricListOptions{MetricLabelSelector: "foo"}
+	res, err := metricConverter.ConvertListOptionsToPreferredVersion(opts)
+	require.NoError(t, err)
+	require.Equal(t, &cmv1beta2.MetricListOptions{
+		TypeMeta:            metav1.TypeMeta{Kind: "MetricListOptions", APIVersion: fakeVersion.String()},
+		MetricLabelSelector: "foo",
+	}, res)
+}
+
+// TestMetricConverterConversionHooks verifies that a hook registered for a specific
+// from/to version pair runs after the generic round-trip through the internal version,
+// and can overwrite a field on the converted object.
+func TestMetricConverterConversionHooks(t *testing.T) {
+	metricConverter := NewMetricConverter(func() (*metav1.APIGroup, error) {
+		return makeAPIGroup("v1beta1", "v1beta1", "v1beta2"), nil
+	})
+
+	var hookCalls int
+	metricConverter.RegisterConversionFunc(cmv1beta2.SchemeGroupVersion, cmv1beta1.SchemeGroupVersion,
+		func(in, out runtime.Object) error {
+			hookCalls++
+			inOpts, ok := in.(*cmv1beta2.MetricListOptions)
+			if !ok {
+				return fmt.Errorf("unexpected input type %T", in)
+			}
+			outOpts, ok := out.(*cmv1beta1.MetricListOptions)
+			if !ok {
+				return fmt.Errorf("unexpected output type %T", out)
+			}
+			// pretend MetricLabelSelector is a synthetic field that needs custom handling
+			// across versions, rather than a straight copy.
+			outOpts.MetricLabelSelector = "converted:" + inOpts.MetricLabelSelector
+			return nil
+		})
+
+	in := &cmv1beta2.MetricListOptions{
+		TypeMeta:            metav1.TypeMeta{Kind: "MetricListOptions", APIVersion: cmv1beta2.SchemeGroupVersion.String()},
+		MetricLabelSelector: "foo",
+	}
+	res, err := metricConverter.UnsafeConvertToVersionVia(in, cmv1beta1.SchemeGroupVersion)
+	require.NoError(t, err)
+	require.Equal(t, 1, hookCalls)
+	require.Equal(t, &cmv1beta1.MetricListOptions{
+		TypeMeta:            metav1.TypeMeta{Kind: "MetricListOptions", APIVersion: cmv1beta1.SchemeGroupVersion.String()},
+		MetricLabelSelector: "converted:foo",
+	}, res)
+}
+
+// TestMetricConverterConversionHookError verifies that an error from a registered hook is
+// surfaced as a *ConversionHookError, rather than being silently swallowed.
+func TestMetricConverterConversionHookError(t *testing.T) {
+	metricConverter := NewMetricConverter(func() (*metav1.APIGroup, error) {
+		return makeAPIGroup("v1beta1", "v1beta1", "v1beta2"), nil
+	})
+	metricConverter.RegisterConversionFunc(cmv1beta2.SchemeGroupVersion, cmv1beta1.SchemeGroupVersion,
+		func(in, out runtime.Object) error {
+			return fmt.Errorf("boom")
+		})
+
+	in := &cmv1beta2.MetricListOptions{
+		TypeMeta: metav1.TypeMeta{Kind: "MetricListOptions", APIVersion: cmv1beta2.SchemeGroupVersion.String()},
+	}
+	_, err := metricConverter.UnsafeConvertToVersionVia(in, cmv1beta1.SchemeGroupVersion)
+	require.Error(t, err)
+
+	var hookErr *ConversionHookError
+	require.True(t, errors.As(err, &hookErr))
+	require.Equal(t, cmv1beta2.SchemeGroupVersion, hookErr.From)
+	require.Equal(t, cmv1beta1.SchemeGroupVersion, hookErr.To)
+}
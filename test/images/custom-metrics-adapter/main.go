@@ -0,0 +1,88 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command custom-metrics-adapter runs the e2e custom and external metrics adapter used by
+// the instrumentation e2e tests: it registers the custom.metrics.k8s.io and
+// external.metrics.k8s.io APIServices backed by pkg/provider.E2EProvider, and serves that
+// provider's write routes so tests can seed the values those APIServices read back.
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"k8s.io/apiserver/pkg/util/wait"
+
+	basecmd "sigs.k8s.io/custom-metrics-apiserver/pkg/cmd"
+
+	"k8s.io/kubernetes/test/images/custom-metrics-adapter/pkg/provider"
+)
+
+// Adapter extends the generic custom-metrics-apiserver command with the e2e provider and the
+// flags that configure it.
+type Adapter struct {
+	basecmd.AdapterBase
+
+	// MetricTTL is how long a metric series may go without a write before the provider's
+	// background GC evicts it. Set via the --metric-ttl flag.
+	MetricTTL time.Duration
+}
+
+func (a *Adapter) makeProvider() (*provider.E2EProvider, error) {
+	client, err := a.DynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	mapper, err := a.RESTMapper()
+	if err != nil {
+		return nil, err
+	}
+	return provider.NewE2EProviderWithOptions(client, mapper, 0, a.MetricTTL).(*provider.E2EProvider), nil
+}
+
+func main() {
+	cmd := &Adapter{}
+	cmd.Flags().DurationVar(&cmd.MetricTTL, "metric-ttl", 10*time.Minute,
+		"how long a metric series may go without a write before it is garbage collected")
+	cmd.Flags().Parse(os.Args[1:])
+
+	testProvider, err := cmd.makeProvider()
+	if err != nil {
+		glog.Fatalf("unable to construct e2e metrics provider: %v", err)
+	}
+
+	// Registers the custom.metrics.k8s.io and external.metrics.k8s.io APIServices, both
+	// backed by testProvider, with the generic apiserver cmd.Run starts below.
+	cmd.WithCustomMetrics(testProvider)
+	cmd.WithExternalMetrics(testProvider)
+
+	// The generic apiserver above only serves reads; seed it via testProvider's own
+	// write routes (POST .../write-metrics/...) on a plain HTTP server, the way e2e tests
+	// expect to reach this adapter.
+	container := restful.NewContainer()
+	container.Add(testProvider.WebService())
+	go func() {
+		glog.Fatal(http.ListenAndServe(":8080", container))
+	}()
+
+	if err := cmd.Run(wait.NeverStop); err != nil {
+		glog.Fatalf("unable to run custom metrics adapter: %v", err)
+	}
+}
@@ -17,8 +17,14 @@ limitations under the License.
 package provider
 
 import (
+	"bytes"
+	"container/heap"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/emicklei/go-restful"
@@ -30,35 +36,304 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
 
-	"github.com/kubernetes-incubator/custom-metrics-apiserver/pkg/provider"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
 )
 
 type E2EProvider struct {
 	client dynamic.ClientPool
 	mapper apimeta.RESTMapper
 
-	values map[CustomMetricResource]int64
+	historyCapacity int
+
+	// mu guards values, externalValues, gcHeap and externalGCHeap, all of which
+	// updateResource, updateExternalMetric, the read path and the background GC touch
+	// concurrently.
+	mu             sync.RWMutex
+	values         map[CustomMetricResource]*seriesEntry
+	gcHeap         seriesHeap
+	externalValues map[ExternalMetricResource]*externalSeriesEntry
+	externalGCHeap externalSeriesHeap
+	evictions      int64
 }
 
 type MetricValue struct {
-	Value int64
+	Value     int64
+	Labels    map[string]string
+	Timestamp time.Time
+	// Append, when true, adds this write to the series' history instead of overwriting the
+	// most recently recorded sample. Defaults to false so existing callers keep seeing a
+	// single current value per series.
+	Append bool `json:"append,omitempty"`
 }
 
+// defaultHistoryCapacity bounds how many samples a sampleBuffer retains per series when the
+// caller doesn't request a different capacity via NewE2EProviderWithHistoryCapacity.
+const defaultHistoryCapacity = 1024
+
+// defaultMetricTTL bounds how long a series may go without a write before the background GC
+// evicts it, when the caller doesn't request a different TTL via NewE2EProviderWithOptions.
+const defaultMetricTTL = 10 * time.Minute
+
+// seriesEntry is what p.values stores for each tracked CustomMetricResource: its sample
+// history plus the time it was last written. lastUpdated is what the GC heap sorts on, and
+// heapIndex lets heap.Fix relocate this entry in O(log n) after a write touches it.
+type seriesEntry struct {
+	key         CustomMetricResource
+	buffer      *sampleBuffer
+	lastUpdated time.Time
+	heapIndex   int
+}
+
+// seriesHeap is a container/heap.Interface min-heap of *seriesEntry ordered by lastUpdated,
+// so the GC can repeatedly evict the least-recently-written series without scanning every
+// entry in p.values on each tick.
+type seriesHeap []*seriesEntry
+
+func (h seriesHeap) Len() int { return len(h) }
+
+func (h seriesHeap) Less(i, j int) bool { return h[i].lastUpdated.Before(h[j].lastUpdated) }
+
+func (h seriesHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *seriesHeap) Push(x interface{}) {
+	entry := x.(*seriesEntry)
+	entry.heapIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *seriesHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.heapIndex = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// externalSeriesEntry is seriesEntry's counterpart for p.externalValues: it gives external
+// metric series the same bounded sample history and TTL-based GC eligibility that custom
+// metric series get, instead of accumulating every write forever.
+type externalSeriesEntry struct {
+	key         ExternalMetricResource
+	buffer      *sampleBuffer
+	lastUpdated time.Time
+	heapIndex   int
+}
+
+// externalSeriesHeap is seriesHeap's counterpart for *externalSeriesEntry.
+type externalSeriesHeap []*externalSeriesEntry
+
+func (h externalSeriesHeap) Len() int { return len(h) }
+
+func (h externalSeriesHeap) Less(i, j int) bool { return h[i].lastUpdated.Before(h[j].lastUpdated) }
+
+func (h externalSeriesHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *externalSeriesHeap) Push(x interface{}) {
+	entry := x.(*externalSeriesEntry)
+	entry.heapIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *externalSeriesHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.heapIndex = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// metricSample is a single (timestamp, value) point recorded for a series.
+type metricSample struct {
+	Value     int64
+	Timestamp time.Time
+}
+
+// sampleBuffer is a fixed-capacity ring buffer of metricSamples for a single
+// CustomMetricResource. It lets a series keep a bounded history instead of only the most
+// recent write, so valueFor can compute windowed aggregates (see extractWindowOptions)
+// without the map of series growing without bound.
+type sampleBuffer struct {
+	samples  []metricSample
+	capacity int
+	next     int
+	full     bool
+}
+
+func newSampleBuffer(capacity int) *sampleBuffer {
+	if capacity <= 0 {
+		capacity = defaultHistoryCapacity
+	}
+	return &sampleBuffer{capacity: capacity}
+}
+
+// append records sample as a new point, overwriting the oldest sample once the buffer is at
+// capacity.
+func (b *sampleBuffer) append(sample metricSample) {
+	if b.samples == nil {
+		b.samples = make([]metricSample, b.capacity)
+	}
+	b.samples[b.next] = sample
+	b.next = (b.next + 1) % b.capacity
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// overwriteLast replaces the most recently recorded sample with sample, or appends it if the
+// buffer is empty. This is the default write behavior, matching the historical
+// "most recent value wins" semantics of E2EProvider.
+func (b *sampleBuffer) overwriteLast(sample metricSample) {
+	if b.len() == 0 {
+		b.append(sample)
+		return
+	}
+	b.samples[(b.next-1+b.capacity)%b.capacity] = sample
+}
+
+func (b *sampleBuffer) len() int {
+	if b.full {
+		return b.capacity
+	}
+	return b.next
+}
+
+// latest returns the most recently recorded sample, if any.
+func (b *sampleBuffer) latest() (metricSample, bool) {
+	if b.len() == 0 {
+		return metricSample{}, false
+	}
+	return b.samples[(b.next-1+b.capacity)%b.capacity], true
+}
+
+// orderedSince returns the buffer's samples with a Timestamp at or after cutoff, oldest
+// first.
+func (b *sampleBuffer) orderedSince(cutoff time.Time) []metricSample {
+	n := b.len()
+	start := 0
+	if b.full {
+		start = b.next
+	}
+	var out []metricSample
+	for i := 0; i < n; i++ {
+		sample := b.samples[(start+i)%b.capacity]
+		if !sample.Timestamp.Before(cutoff) {
+			out = append(out, sample)
+		}
+	}
+	return out
+}
+
+// CustomMetricResource keys a stored custom metric value. Labels is the canonical,
+// comparable string form of the series' label set (see labels.Set.String), which lets the
+// same object expose several distinct series under the same metric name (e.g.
+// http_requests{code="200"} vs http_requests{code="500"} on the same pod), differentiated
+// by the metricSelector callers pass alongside the object selector.
 type CustomMetricResource struct {
 	provider.CustomMetricInfo
 
 	Name      string
 	Namespace string
+	Labels    string
+}
+
+// ExternalMetricValue is the body accepted by the external metrics write routes.
+type ExternalMetricValue struct {
+	Value     int64             `json:"value"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp *metav1.Time      `json:"timestamp,omitempty"`
+	// Append, when true, adds this write to the series' history instead of overwriting the
+	// most recently recorded sample, mirroring MetricValue.Append.
+	Append bool `json:"append,omitempty"`
 }
 
-func NewE2EProvider(client dynamic.ClientPool, mapper apimeta.RESTMapper) provider.CustomMetricsProvider {
-	return &E2EProvider{
-		client: client,
-		mapper: mapper,
-		values: make(map[CustomMetricResource]int64),
+// ExternalMetricResource keys a stored external metric by its name and label set, since
+// external metrics (unlike custom metrics) aren't scoped to a Kubernetes object.
+type ExternalMetricResource struct {
+	MetricName string
+	Labels     string
+}
+
+func NewE2EProvider(client dynamic.ClientPool, mapper apimeta.RESTMapper) provider.MetricsProvider {
+	return NewE2EProviderWithOptions(client, mapper, defaultHistoryCapacity, defaultMetricTTL)
+}
+
+// NewE2EProviderWithHistoryCapacity is like NewE2EProvider, but lets the caller bound how
+// many samples each series' sampleBuffer retains instead of taking defaultHistoryCapacity.
+func NewE2EProviderWithHistoryCapacity(client dynamic.ClientPool, mapper apimeta.RESTMapper, historyCapacity int) provider.MetricsProvider {
+	return NewE2EProviderWithOptions(client, mapper, historyCapacity, defaultMetricTTL)
+}
+
+// NewE2EProviderWithOptions is the fully-configurable constructor behind NewE2EProvider and
+// NewE2EProviderWithHistoryCapacity. ttl bounds how long a series may go without a write
+// before the background GC evicts it; it's wired to the adapter's --metric-ttl flag.
+func NewE2EProviderWithOptions(client dynamic.ClientPool, mapper apimeta.RESTMapper, historyCapacity int, ttl time.Duration) provider.MetricsProvider {
+	if historyCapacity <= 0 {
+		historyCapacity = defaultHistoryCapacity
+	}
+	if ttl <= 0 {
+		ttl = defaultMetricTTL
+	}
+	p := &E2EProvider{
+		client:          client,
+		mapper:          mapper,
+		historyCapacity: historyCapacity,
+		values:          make(map[CustomMetricResource]*seriesEntry),
+		externalValues:  make(map[ExternalMetricResource]*externalSeriesEntry),
+	}
+	go p.runGC(ttl)
+	return p
+}
+
+// runGC periodically evicts series that haven't been written to in ttl. It checks at ttl/2
+// (floored at one second) so a short TTL used in a test doesn't have to wait a full period
+// past expiry to see an eviction.
+func (p *E2EProvider) runGC(ttl time.Duration) {
+	interval := ttl / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.evictExpired(ttl)
+	}
+}
+
+// evictExpired pops series off the front of gcHeap and externalGCHeap while they're older
+// than ttl, which is O(log n) per eviction rather than the O(n) full-map scan a naive sweep
+// would need.
+func (p *E2EProvider) evictExpired(ttl time.Duration) {
+	cutoff := time.Now().Add(-ttl)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.gcHeap.Len() > 0 && p.gcHeap[0].lastUpdated.Before(cutoff) {
+		entry := heap.Pop(&p.gcHeap).(*seriesEntry)
+		delete(p.values, entry.key)
+		p.evictions++
+	}
+	for p.externalGCHeap.Len() > 0 && p.externalGCHeap[0].lastUpdated.Before(cutoff) {
+		entry := heap.Pop(&p.externalGCHeap).(*externalSeriesEntry)
+		delete(p.externalValues, entry.key)
+		p.evictions++
 	}
 }
 
@@ -78,6 +353,14 @@ func (p *E2EProvider) WebService() *restful.WebService {
 	// Namespaces, where {resourceType} == "namespaces" to match API
 	ws.Route(ws.POST("/{resourceType}/{name}/metrics/{metric}").To(p.updateResource).
 		Param(ws.BodyParameter("value", "value to set metric").DataType("integer").DefaultValue("0")))
+
+	// External metrics, which aren't scoped to a Kubernetes object
+	ws.Route(ws.POST("/external/{metric}").To(p.updateExternalMetric).
+		Param(ws.BodyParameter("value", "value to set metric").DataType("integer").DefaultValue("0")))
+
+	// A Prometheus-compatible scrape endpoint, so operators and e2e tests can confirm what
+	// the adapter currently believes without racing the custom-metrics API.
+	ws.Route(ws.GET("/metrics-series").To(p.metricsSeries))
 	return ws
 }
 
@@ -115,11 +398,273 @@ func (p *E2EProvider) updateResource(request *restful.Request, response *restful
 		CustomMetricInfo: info,
 		Name:             name,
 		Namespace:        namespace,
+		Labels:           labels.Set(value.Labels).String(),
+	}
+
+	ts := value.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	sample := metricSample{Value: value.Value, Timestamp: ts}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.values[metricInfo]
+	if !ok {
+		entry = &seriesEntry{key: metricInfo, buffer: newSampleBuffer(p.historyCapacity)}
+		p.values[metricInfo] = entry
+		heap.Push(&p.gcHeap, entry)
+	}
+	if value.Append {
+		entry.buffer.append(sample)
+	} else {
+		entry.buffer.overwriteLast(sample)
+	}
+	entry.lastUpdated = time.Now()
+	heap.Fix(&p.gcHeap, entry.heapIndex)
+}
+
+func (p *E2EProvider) updateExternalMetric(request *restful.Request, response *restful.Response) {
+	metricName := request.PathParameter("metric")
+
+	value := &ExternalMetricValue{}
+	if err := request.ReadEntity(&value); err != nil {
+		response.WriteErrorString(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metricInfo := ExternalMetricResource{
+		MetricName: metricName,
+		Labels:     labels.Set(value.Labels).String(),
+	}
+
+	sample := metricSample{Value: value.Value, Timestamp: externalMetricTimestamp(value.Timestamp).Time}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.externalValues[metricInfo]
+	if !ok {
+		entry = &externalSeriesEntry{key: metricInfo, buffer: newSampleBuffer(p.historyCapacity)}
+		p.externalValues[metricInfo] = entry
+		heap.Push(&p.externalGCHeap, entry)
+	}
+	if value.Append {
+		entry.buffer.append(sample)
+	} else {
+		entry.buffer.overwriteLast(sample)
 	}
-	p.values[metricInfo] = value.Value
+	entry.lastUpdated = time.Now()
+	heap.Fix(&p.externalGCHeap, entry.heapIndex)
 }
 
-func (p *E2EProvider) valueFor(groupResource schema.GroupResource, metricName, namespace, name string, namespaced bool) (int64, error) {
+func externalMetricTimestamp(ts *metav1.Time) metav1.Time {
+	if ts != nil {
+		return *ts
+	}
+	return metav1.Time{Time: time.Now()}
+}
+
+// metricsSeries renders the latest sample of every series in p.values and p.externalValues
+// (earlier samples in a series' history are not rendered), plus the GC's tracked-series count
+// and eviction total, in the Prometheus text exposition format, so
+// operators and e2e tests can confirm what the adapter currently believes without racing the
+// custom-metrics API.
+func (p *E2EProvider) metricsSeries(request *restful.Request, response *restful.Response) {
+	var buf bytes.Buffer
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	fmt.Fprintln(&buf, "# TYPE custom_metrics_adapter_value gauge")
+	for resource, entry := range p.values {
+		latest, ok := entry.buffer.latest()
+		if !ok {
+			continue
+		}
+		writeGaugeSample(&buf, "custom_metrics_adapter_value", resource.Metric, resource.GroupResource.Resource,
+			resource.Namespace, resource.Name, resource.Labels, float64(latest.Value), latest.Timestamp)
+	}
+
+	fmt.Fprintln(&buf, "# TYPE custom_metrics_adapter_external_value gauge")
+	for resource, entry := range p.externalValues {
+		latest, ok := entry.buffer.latest()
+		if !ok {
+			continue
+		}
+		writeGaugeSample(&buf, "custom_metrics_adapter_external_value", resource.MetricName, "", "", "",
+			resource.Labels, float64(latest.Value), latest.Timestamp)
+	}
+
+	now := time.Now()
+	fmt.Fprintln(&buf, "# TYPE custom_metrics_adapter_tracked_series gauge")
+	fmt.Fprintf(&buf, "custom_metrics_adapter_tracked_series %d %d\n", len(p.values)+len(p.externalValues), now.UnixNano()/int64(time.Millisecond))
+	fmt.Fprintln(&buf, "# TYPE custom_metrics_adapter_evictions_total counter")
+	fmt.Fprintf(&buf, "custom_metrics_adapter_evictions_total %d %d\n", p.evictions, now.UnixNano()/int64(time.Millisecond))
+
+	response.AddHeader("Content-Type", "text/plain; version=0.0.4")
+	response.Write(buf.Bytes())
+}
+
+// writeGaugeSample writes a single Prometheus sample line for family, with labels
+// "metric", "resource", "namespace" and "name" (any of which are omitted when empty) plus
+// whatever per-series labels are encoded in labelSet.
+func writeGaugeSample(buf *bytes.Buffer, family, metricName, resourceType, namespace, name, labelSet string, value float64, ts time.Time) {
+	seriesLabels, err := labels.ConvertSelectorToLabelsMap(labelSet)
+	if err != nil {
+		glog.Errorf("unable to parse stored labels %q for metric %q: %v", labelSet, metricName, err)
+		seriesLabels = nil
+	}
+
+	pairs := []string{fmt.Sprintf("metric=%q", metricName)}
+	if resourceType != "" {
+		pairs = append(pairs, fmt.Sprintf("resource=%q", resourceType))
+	}
+	if namespace != "" {
+		pairs = append(pairs, fmt.Sprintf("namespace=%q", namespace))
+	}
+	if name != "" {
+		pairs = append(pairs, fmt.Sprintf("name=%q", name))
+	}
+	for _, key := range sortedKeys(seriesLabels) {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, seriesLabels[key]))
+	}
+
+	fmt.Fprintf(buf, "%s{%s} %s %d\n", family, strings.Join(pairs, ","),
+		strconv.FormatFloat(value, 'g', -1, 64), ts.UnixNano()/int64(time.Millisecond))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// windowSelectorKey and aggSelectorKey are reserved metricSelector label keys that don't
+// identify a series to match against; extractWindowOptions strips them out before the
+// remaining selector is used for label matching.
+const (
+	windowSelectorKey = "window"
+	aggSelectorKey    = "agg"
+)
+
+// windowOptions is what extractWindowOptions parses out of a metricSelector's reserved keys.
+type windowOptions struct {
+	window time.Duration
+	agg    string
+}
+
+// extractWindowOptions pulls the reserved "window"/"agg" requirements out of selector,
+// returning a selector with only the remaining (real label-matching) requirements alongside
+// the parsed windowOptions. A metricSelector with neither key returns an empty windowOptions
+// and behaves exactly as before: valueFor returns the latest sample.
+func extractWindowOptions(selector labels.Selector) (labels.Selector, windowOptions, error) {
+	var opts windowOptions
+
+	requirements, selectable := selector.Requirements()
+	if !selectable {
+		return selector, opts, nil
+	}
+
+	remaining := labels.NewSelector()
+	for _, req := range requirements {
+		switch req.Key() {
+		case windowSelectorKey:
+			values := req.Values().List()
+			if len(values) != 1 {
+				return nil, opts, fmt.Errorf("expected exactly one value for metricSelector key %q, got %d", windowSelectorKey, len(values))
+			}
+			window, err := time.ParseDuration(values[0])
+			if err != nil {
+				return nil, opts, fmt.Errorf("invalid %q value %q: %v", windowSelectorKey, values[0], err)
+			}
+			opts.window = window
+		case aggSelectorKey:
+			values := req.Values().List()
+			if len(values) != 1 {
+				return nil, opts, fmt.Errorf("expected exactly one value for metricSelector key %q, got %d", aggSelectorKey, len(values))
+			}
+			opts.agg = values[0]
+		default:
+			remaining = remaining.Add(req)
+		}
+	}
+	return remaining, opts, nil
+}
+
+// aggregatedValue is what valueFor computes for a series: either the latest sample verbatim,
+// or an aggregate over a trailing window. WindowSeconds is non-nil only in the latter case.
+//
+// custom_metrics.MetricValue has no field to carry WindowSeconds on the wire, so metricFor
+// only uses it for logging; it's available to callers that work with aggregatedValue
+// directly (e.g. tests) that want to assert on the window that was applied.
+type aggregatedValue struct {
+	Value         int64
+	Timestamp     time.Time
+	WindowSeconds *int64
+}
+
+// aggregate computes an aggregatedValue for buf according to opts. With no window requested,
+// it's just the latest sample. With a window, it aggregates every sample at or after
+// latest.Timestamp-opts.window using opts.agg (default "last").
+func aggregate(buf *sampleBuffer, opts windowOptions) (aggregatedValue, error) {
+	latest, ok := buf.latest()
+	if !ok {
+		return aggregatedValue{}, fmt.Errorf("no samples recorded")
+	}
+	if opts.window <= 0 {
+		return aggregatedValue{Value: latest.Value, Timestamp: latest.Timestamp}, nil
+	}
+
+	windowed := buf.orderedSince(latest.Timestamp.Add(-opts.window))
+	if len(windowed) == 0 {
+		windowed = []metricSample{latest}
+	}
+
+	value, err := computeAggregate(opts.agg, windowed)
+	if err != nil {
+		return aggregatedValue{}, err
+	}
+	windowSeconds := int64(opts.window / time.Second)
+	return aggregatedValue{Value: value, Timestamp: latest.Timestamp, WindowSeconds: &windowSeconds}, nil
+}
+
+// computeAggregate reduces samples (oldest first) to a single value according to agg.
+func computeAggregate(agg string, samples []metricSample) (int64, error) {
+	switch agg {
+	case "", "last":
+		return samples[len(samples)-1].Value, nil
+	case "max":
+		max := samples[0].Value
+		for _, sample := range samples[1:] {
+			if sample.Value > max {
+				max = sample.Value
+			}
+		}
+		return max, nil
+	case "avg":
+		var sum int64
+		for _, sample := range samples {
+			sum += sample.Value
+		}
+		return sum / int64(len(samples)), nil
+	case "rate":
+		first, last := samples[0], samples[len(samples)-1]
+		elapsed := last.Timestamp.Sub(first.Timestamp).Seconds()
+		if elapsed <= 0 {
+			return 0, nil
+		}
+		return int64(float64(last.Value-first.Value) / elapsed), nil
+	default:
+		return 0, fmt.Errorf("unknown metricSelector %q value %q", aggSelectorKey, agg)
+	}
+}
+
+func (p *E2EProvider) valueFor(groupResource schema.GroupResource, metricName, namespace, name string, namespaced bool, metricSelector labels.Selector) (aggregatedValue, error) {
 	info := provider.CustomMetricInfo{
 		GroupResource: groupResource,
 		Metric:        metricName,
@@ -131,26 +676,44 @@ func (p *E2EProvider) valueFor(groupResource schema.GroupResource, metricName, n
 		glog.Errorf("Error normalizing info: %s", err)
 	}
 
-	metricInfo := CustomMetricResource{
-		CustomMetricInfo: info,
-		Name:             name,
-		Namespace:        namespace,
+	labelSelector, windowOpts, err := extractWindowOptions(metricSelector)
+	if err != nil {
+		return aggregatedValue{}, err
 	}
 
-	value, found := p.values[metricInfo]
-	if !found {
-		return 0, provider.NewMetricNotFoundForError(groupResource, metricName, name)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for resource, entry := range p.values {
+		if resource.CustomMetricInfo != info || resource.Name != name || resource.Namespace != namespace {
+			continue
+		}
+
+		resourceLabels, err := labels.ConvertSelectorToLabelsMap(resource.Labels)
+		if err != nil {
+			glog.Errorf("unable to parse stored labels %q for metric %q: %v", resource.Labels, metricName, err)
+			continue
+		}
+		if !labelSelector.Matches(resourceLabels) {
+			continue
+		}
+
+		return aggregate(entry.buffer, windowOpts)
 	}
 
-	return value, nil
+	return aggregatedValue{}, provider.NewMetricNotFoundForError(groupResource, metricName, name)
 }
 
-func (p *E2EProvider) metricFor(value int64, groupResource schema.GroupResource, namespace string, name string, metricName string) (*custom_metrics.MetricValue, error) {
+func (p *E2EProvider) metricFor(value aggregatedValue, groupResource schema.GroupResource, namespace string, name string, metricName string) (*custom_metrics.MetricValue, error) {
 	kind, err := p.mapper.KindFor(groupResource.WithVersion(""))
 	if err != nil {
 		return nil, err
 	}
 
+	if value.WindowSeconds != nil {
+		glog.V(4).Infof("metric %q for %s/%s aggregated over a %ds window", metricName, namespace, name, *value.WindowSeconds)
+	}
+
 	return &custom_metrics.MetricValue{
 		DescribedObject: custom_metrics.ObjectReference{
 			APIVersion: groupResource.Group + "/" + runtime.APIVersionInternal,
@@ -159,12 +722,12 @@ func (p *E2EProvider) metricFor(value int64, groupResource schema.GroupResource,
 			Namespace:  namespace,
 		},
 		MetricName: metricName,
-		Timestamp:  metav1.Time{time.Now()},
-		Value:      *resource.NewMilliQuantity(value*1000, resource.DecimalSI),
+		Timestamp:  metav1.Time{value.Timestamp},
+		Value:      *resource.NewMilliQuantity(value.Value*1000, resource.DecimalSI),
 	}, nil
 }
 
-func (p *E2EProvider) metricsFor(groupResource schema.GroupResource, metricName string, list runtime.Object, namespaced bool) (*custom_metrics.MetricValueList, error) {
+func (p *E2EProvider) metricsFor(groupResource schema.GroupResource, metricName string, list runtime.Object, namespaced bool, metricSelector labels.Selector) (*custom_metrics.MetricValueList, error) {
 	if !apimeta.IsListType(list) {
 		return nil, fmt.Errorf("returned object was not a list")
 	}
@@ -173,7 +736,7 @@ func (p *E2EProvider) metricsFor(groupResource schema.GroupResource, metricName
 
 	err := apimeta.EachListItem(list, func(item runtime.Object) error {
 		objMeta := item.(metav1.Object)
-		value, err := p.valueFor(groupResource, metricName, objMeta.GetNamespace(), objMeta.GetName(), namespaced)
+		value, err := p.valueFor(groupResource, metricName, objMeta.GetNamespace(), objMeta.GetName(), namespaced, metricSelector)
 		if err != nil {
 			if apierr.IsNotFound(err) {
 				return nil
@@ -198,17 +761,22 @@ func (p *E2EProvider) metricsFor(groupResource schema.GroupResource, metricName
 	}, nil
 }
 
-func (p *E2EProvider) GetRootScopedMetricByName(groupResource schema.GroupResource, name string, metricName string) (*custom_metrics.MetricValue, error) {
-	value, err := p.valueFor(groupResource, metricName, "", name, false)
+// GetMetricByName implements the current custom-metrics-apiserver provider interface, which
+// merges what used to be separate root-scoped and namespaced lookups into a single method
+// keyed off info.Namespaced, and threads through the metricSelector that custom.metrics.k8s.io
+// v1beta2 passes so that series on the same object can be told apart (e.g.
+// http_requests{code="200"} vs {code="500"}).
+func (p *E2EProvider) GetMetricByName(name types.NamespacedName, info provider.CustomMetricInfo, metricSelector labels.Selector) (*custom_metrics.MetricValue, error) {
+	value, err := p.valueFor(info.GroupResource, info.Metric, name.Namespace, name.Name, info.Namespaced, metricSelector)
 	if err != nil {
 		return nil, err
 	}
-	return p.metricFor(value, groupResource, "", name, metricName)
+	return p.metricFor(value, info.GroupResource, name.Namespace, name.Name, info.Metric)
 }
 
-func (p *E2EProvider) GetRootScopedMetricBySelector(groupResource schema.GroupResource, selector labels.Selector, metricName string) (*custom_metrics.MetricValueList, error) {
+func (p *E2EProvider) GetMetricBySelector(namespace string, selector labels.Selector, info provider.CustomMetricInfo, metricSelector labels.Selector) (*custom_metrics.MetricValueList, error) {
 	// construct a client to list the names of objects matching the label selector
-	client, err := p.client.ClientForGroupVersionResource(groupResource.WithVersion(""))
+	client, err := p.client.ClientForGroupVersionResource(info.GroupResource.WithVersion(""))
 	if err != nil {
 		glog.Errorf("unable to construct dynamic client to list matching resource names: %v", err)
 		// don't leak implementation details to the user
@@ -217,50 +785,27 @@ func (p *E2EProvider) GetRootScopedMetricBySelector(groupResource schema.GroupRe
 
 	// we can construct a this APIResource ourself, since the dynamic client only uses Name and Namespaced
 	apiRes := &metav1.APIResource{
-		Name:       groupResource.Resource,
-		Namespaced: false,
-	}
-
-	matchingObjectsRaw, err := client.Resource(apiRes, "").
-		List(metav1.ListOptions{LabelSelector: selector.String()})
-	if err != nil {
-		return nil, err
-	}
-	return p.metricsFor(groupResource, metricName, matchingObjectsRaw, false)
-}
-
-func (p *E2EProvider) GetNamespacedMetricByName(groupResource schema.GroupResource, namespace string, name string, metricName string) (*custom_metrics.MetricValue, error) {
-	value, err := p.valueFor(groupResource, metricName, namespace, name, true)
-	if err != nil {
-		return nil, err
+		Name:       info.GroupResource.Resource,
+		Namespaced: info.Namespaced,
 	}
-	return p.metricFor(value, groupResource, namespace, name, metricName)
-}
 
-func (p *E2EProvider) GetNamespacedMetricBySelector(groupResource schema.GroupResource, namespace string, selector labels.Selector, metricName string) (*custom_metrics.MetricValueList, error) {
-	// construct a client to list the names of objects matching the label selector
-	client, err := p.client.ClientForGroupVersionResource(groupResource.WithVersion(""))
-	if err != nil {
-		glog.Errorf("unable to construct dynamic client to list matching resource names: %v", err)
-		// don't leak implementation details to the user
-		return nil, apierr.NewInternalError(fmt.Errorf("unable to list matching resources"))
-	}
-
-	// we can construct a this APIResource ourself, since the dynamic client only uses Name and Namespaced
-	apiRes := &metav1.APIResource{
-		Name:       groupResource.Resource,
-		Namespaced: true,
+	listNamespace := namespace
+	if !info.Namespaced {
+		listNamespace = ""
 	}
 
-	matchingObjectsRaw, err := client.Resource(apiRes, namespace).
+	matchingObjectsRaw, err := client.Resource(apiRes, listNamespace).
 		List(metav1.ListOptions{LabelSelector: selector.String()})
 	if err != nil {
 		return nil, err
 	}
-	return p.metricsFor(groupResource, metricName, matchingObjectsRaw, true)
+	return p.metricsFor(info.GroupResource, info.Metric, matchingObjectsRaw, info.Namespaced, metricSelector)
 }
 
 func (p *E2EProvider) ListAllMetrics() []provider.CustomMetricInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	// Get unique CustomMetricInfos from wrapper CustomMetricResources
 	infos := make(map[provider.CustomMetricInfo]struct{})
 	for resource := range p.values {
@@ -275,3 +820,64 @@ func (p *E2EProvider) ListAllMetrics() []provider.CustomMetricInfo {
 
 	return metrics
 }
+
+// GetExternalMetric returns one current value per external metric series matching selector,
+// the same "latest sample, or a windowed aggregate if selector carries the reserved
+// window/agg keys" semantics valueFor applies to custom metrics (see extractWindowOptions),
+// rather than every historical write ever recorded for that series.
+func (p *E2EProvider) GetExternalMetric(namespace string, selector labels.Selector, info provider.ExternalMetricInfo) (*external_metrics.ExternalMetricValueList, error) {
+	labelSelector, windowOpts, err := extractWindowOptions(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	matchingValues := make([]external_metrics.ExternalMetricValue, 0)
+	for key, entry := range p.externalValues {
+		if key.MetricName != info.Metric {
+			continue
+		}
+		resourceLabels, err := labels.ConvertSelectorToLabelsMap(key.Labels)
+		if err != nil {
+			glog.Errorf("unable to parse stored labels %q for metric %q: %v", key.Labels, key.MetricName, err)
+			continue
+		}
+		if !labelSelector.Matches(resourceLabels) {
+			continue
+		}
+
+		value, err := aggregate(entry.buffer, windowOpts)
+		if err != nil {
+			continue
+		}
+		matchingValues = append(matchingValues, external_metrics.ExternalMetricValue{
+			MetricName:   key.MetricName,
+			MetricLabels: resourceLabels,
+			Timestamp:    metav1.Time{Time: value.Timestamp},
+			Value:        *resource.NewMilliQuantity(value.Value*1000, resource.DecimalSI),
+		})
+	}
+
+	return &external_metrics.ExternalMetricValueList{
+		Items: matchingValues,
+	}, nil
+}
+
+func (p *E2EProvider) ListAllExternalMetrics() []provider.ExternalMetricInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	infos := make(map[string]struct{})
+	for resource := range p.externalValues {
+		infos[resource.MetricName] = struct{}{}
+	}
+
+	metrics := make([]provider.ExternalMetricInfo, 0, len(infos))
+	for metricName := range infos {
+		metrics = append(metrics, provider.ExternalMetricInfo{Metric: metricName})
+	}
+
+	return metrics
+}
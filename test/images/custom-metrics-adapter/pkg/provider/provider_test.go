@@ -0,0 +1,422 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provider
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+// newTestProvider returns an E2EProvider suitable for exercising its read/write logic directly
+// in-package, without a real dynamic client (nothing here needs to list live objects). Its
+// mapper is a real RESTMapper with a Pod kind registered, since valueFor and metricFor both
+// call through p.mapper unconditionally.
+func newTestProvider() *E2EProvider {
+	mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{{Group: "", Version: "v1"}})
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, apimeta.RESTScopeNamespace)
+
+	return &E2EProvider{
+		mapper:          mapper,
+		historyCapacity: defaultHistoryCapacity,
+		values:          make(map[CustomMetricResource]*seriesEntry),
+		externalValues:  make(map[ExternalMetricResource]*externalSeriesEntry),
+	}
+}
+
+func TestSampleBufferOverwriteLast(t *testing.T) {
+	buf := newSampleBuffer(4)
+	t0 := time.Unix(0, 0)
+
+	buf.overwriteLast(metricSample{Value: 1, Timestamp: t0})
+	buf.overwriteLast(metricSample{Value: 2, Timestamp: t0.Add(time.Second)})
+
+	if buf.len() != 1 {
+		t.Fatalf("expected overwriteLast to keep a single sample, got %d", buf.len())
+	}
+	latest, ok := buf.latest()
+	if !ok || latest.Value != 2 {
+		t.Fatalf("expected latest value 2, got %+v (ok=%v)", latest, ok)
+	}
+}
+
+func TestSampleBufferAppendWrapsAtCapacity(t *testing.T) {
+	buf := newSampleBuffer(3)
+	t0 := time.Unix(0, 0)
+
+	for i := int64(1); i <= 5; i++ {
+		buf.append(metricSample{Value: i, Timestamp: t0.Add(time.Duration(i) * time.Second)})
+	}
+
+	if buf.len() != 3 {
+		t.Fatalf("expected buffer to be capped at capacity 3, got %d", buf.len())
+	}
+	latest, ok := buf.latest()
+	if !ok || latest.Value != 5 {
+		t.Fatalf("expected latest value 5, got %+v (ok=%v)", latest, ok)
+	}
+
+	ordered := buf.orderedSince(time.Time{})
+	wantValues := []int64{3, 4, 5}
+	if len(ordered) != len(wantValues) {
+		t.Fatalf("expected %d samples after wraparound, got %d: %+v", len(wantValues), len(ordered), ordered)
+	}
+	for i, want := range wantValues {
+		if ordered[i].Value != want {
+			t.Fatalf("expected ordered[%d].Value == %d, got %d", i, want, ordered[i].Value)
+		}
+	}
+}
+
+func TestSampleBufferOrderedSinceCutoff(t *testing.T) {
+	buf := newSampleBuffer(8)
+	t0 := time.Unix(0, 0)
+	for i := int64(0); i < 4; i++ {
+		buf.append(metricSample{Value: i, Timestamp: t0.Add(time.Duration(i) * time.Minute)})
+	}
+
+	windowed := buf.orderedSince(t0.Add(90 * time.Second))
+	if len(windowed) != 2 {
+		t.Fatalf("expected 2 samples at or after cutoff, got %d: %+v", len(windowed), windowed)
+	}
+	if windowed[0].Value != 2 || windowed[1].Value != 3 {
+		t.Fatalf("unexpected windowed samples: %+v", windowed)
+	}
+}
+
+func TestComputeAggregate(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	samples := []metricSample{
+		{Value: 10, Timestamp: t0},
+		{Value: 20, Timestamp: t0.Add(10 * time.Second)},
+		{Value: 30, Timestamp: t0.Add(20 * time.Second)},
+	}
+
+	cases := []struct {
+		agg     string
+		want    int64
+		wantErr bool
+	}{
+		{agg: "", want: 30},
+		{agg: "last", want: 30},
+		{agg: "max", want: 30},
+		{agg: "avg", want: 20},
+		{agg: "rate", want: 1}, // (30-10) samples over 20s == 1/s
+		{agg: "bogus", wantErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := computeAggregate(c.agg, samples)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("agg %q: expected an error, got value %d", c.agg, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("agg %q: unexpected error: %v", c.agg, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("agg %q: expected %d, got %d", c.agg, c.want, got)
+		}
+	}
+}
+
+func TestAggregateAppliesWindow(t *testing.T) {
+	buf := newSampleBuffer(8)
+	t0 := time.Unix(0, 0)
+	buf.append(metricSample{Value: 1, Timestamp: t0})
+	buf.append(metricSample{Value: 2, Timestamp: t0.Add(30 * time.Second)})
+	buf.append(metricSample{Value: 3, Timestamp: t0.Add(60 * time.Second)})
+
+	// No window: just the latest sample.
+	got, err := aggregate(buf, windowOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Value != 3 || got.WindowSeconds != nil {
+		t.Fatalf("expected the latest sample with no WindowSeconds, got %+v", got)
+	}
+
+	// A 40s window over the max aggregation only covers the last two samples (2, 3).
+	got, err = aggregate(buf, windowOptions{window: 40 * time.Second, agg: "max"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Value != 3 {
+		t.Fatalf("expected max 3 over the window, got %d", got.Value)
+	}
+	if got.WindowSeconds == nil || *got.WindowSeconds != 40 {
+		t.Fatalf("expected WindowSeconds == 40, got %+v", got.WindowSeconds)
+	}
+}
+
+func TestExtractWindowOptions(t *testing.T) {
+	selector, err := labels.Parse("window=30s,agg=rate,code=500")
+	if err != nil {
+		t.Fatalf("unable to parse test selector: %v", err)
+	}
+
+	remaining, opts, err := extractWindowOptions(selector)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.window != 30*time.Second {
+		t.Fatalf("expected a 30s window, got %v", opts.window)
+	}
+	if opts.agg != "rate" {
+		t.Fatalf("expected agg %q, got %q", "rate", opts.agg)
+	}
+
+	if !remaining.Matches(labels.Set{"code": "500"}) {
+		t.Fatal("expected the remaining selector, without window/agg, to match the real label")
+	}
+	if remaining.Matches(labels.Set{"code": "200"}) {
+		t.Fatal("expected the remaining selector to still filter on the code label")
+	}
+}
+
+func TestExtractWindowOptionsInvalidDuration(t *testing.T) {
+	selector, err := labels.Parse("window=not-a-duration")
+	if err != nil {
+		t.Fatalf("unable to parse test selector: %v", err)
+	}
+	if _, _, err := extractWindowOptions(selector); err == nil {
+		t.Fatal("expected an error for an invalid window duration")
+	}
+}
+
+// TestUpdateAndGetExternalMetric verifies the external metrics write/read path end to end: a
+// POST to the external write route is reachable through GetExternalMetric, a second write to
+// the same label set overwrites rather than accumulates (the current-value semantics
+// GetExternalMetric is supposed to provide), and ListAllExternalMetrics reports the metric
+// name once it's been written.
+func TestUpdateAndGetExternalMetric(t *testing.T) {
+	p := newTestProvider()
+
+	container := restful.NewContainer()
+	container.Add(p.WebService())
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	post := func(value int64) {
+		body, err := json.Marshal(ExternalMetricValue{Value: value, Labels: map[string]string{"cluster": "test"}})
+		if err != nil {
+			t.Fatalf("unable to marshal request body: %v", err)
+		}
+		resp, err := http.Post(server.URL+"/write-metrics/external/queue-length", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("unable to POST external metric: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 OK from write route, got %d", resp.StatusCode)
+		}
+	}
+
+	post(5)
+	post(9)
+
+	selector := labels.SelectorFromSet(labels.Set{"cluster": "test"})
+	list, err := p.GetExternalMetric("", selector, provider.ExternalMetricInfo{Metric: "queue-length"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("expected the second write to overwrite the first rather than accumulate, got %d items: %+v", len(list.Items), list.Items)
+	}
+	if got := list.Items[0].Value.Value(); got != 9 {
+		t.Fatalf("expected the latest written value 9, got %d", got)
+	}
+
+	infos := p.ListAllExternalMetrics()
+	if len(infos) != 1 || infos[0].Metric != "queue-length" {
+		t.Fatalf("expected ListAllExternalMetrics to report queue-length, got %+v", infos)
+	}
+}
+
+// TestWriteGaugeSample verifies the exact Prometheus sample line writeGaugeSample produces,
+// including that per-series labels are sorted for deterministic output and that empty
+// resource/namespace/name fields (as external metrics pass) are omitted rather than emitted
+// as empty-string labels.
+func TestWriteGaugeSample(t *testing.T) {
+	var buf bytes.Buffer
+	ts := time.Unix(1000, 0)
+
+	writeGaugeSample(&buf, "custom_metrics_adapter_value", "http_requests", "pods", "default", "my-pod",
+		labels.Set{"code": "500", "verb": "GET"}.String(), 42, ts)
+
+	want := `custom_metrics_adapter_value{metric="http_requests",resource="pods",namespace="default",name="my-pod",code="500",verb="GET"} 42 1000000` + "\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected gauge line:\n got:  %q\n want: %q", got, want)
+	}
+
+	buf.Reset()
+	writeGaugeSample(&buf, "custom_metrics_adapter_external_value", "queue-length", "", "", "",
+		labels.Set{"cluster": "test"}.String(), 7, ts)
+
+	want = `custom_metrics_adapter_external_value{metric="queue-length",cluster="test"} 7 1000000` + "\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected external gauge line:\n got:  %q\n want: %q", got, want)
+	}
+}
+
+// TestMetricsSeriesEndpoint verifies the /write-metrics/metrics-series scrape route reports a
+// gauge line for a tracked series plus the tracked_series and evictions_total counters.
+func TestMetricsSeriesEndpoint(t *testing.T) {
+	p := newTestProvider()
+
+	resource := CustomMetricResource{
+		CustomMetricInfo: provider.CustomMetricInfo{
+			GroupResource: schema.GroupResource{Resource: "pods"},
+			Metric:        "http_requests",
+			Namespaced:    true,
+		},
+		Name:      "my-pod",
+		Namespace: "default",
+	}
+	buffer := newSampleBuffer(defaultHistoryCapacity)
+	buffer.overwriteLast(metricSample{Value: 42, Timestamp: time.Unix(1000, 0)})
+	entry := &seriesEntry{key: resource, buffer: buffer, lastUpdated: time.Now()}
+	p.values[resource] = entry
+	heap.Push(&p.gcHeap, entry)
+	p.evictions = 3
+
+	container := restful.NewContainer()
+	container.Add(p.WebService())
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/write-metrics/metrics-series")
+	if err != nil {
+		t.Fatalf("unable to GET metrics-series: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unable to read response body: %v", err)
+	}
+
+	if !strings.Contains(string(body), `custom_metrics_adapter_value{metric="http_requests",resource="pods",namespace="default",name="my-pod"} 42 1000000`) {
+		t.Fatalf("expected the tracked series' gauge line in the response, got:\n%s", body)
+	}
+	if !strings.Contains(string(body), "custom_metrics_adapter_tracked_series 1 ") {
+		t.Fatalf("expected tracked_series to report 1, got:\n%s", body)
+	}
+	if !strings.Contains(string(body), "custom_metrics_adapter_evictions_total 3 ") {
+		t.Fatalf("expected evictions_total to report 3, got:\n%s", body)
+	}
+}
+
+// TestGetMetricByNameFiltersByMetricSelector verifies that two series for the same object and
+// metric name, differentiated only by label set, are told apart via metricSelector: a selector
+// matching one series' labels must not return the other's value, and a selector matching
+// neither must report not-found rather than an arbitrary series.
+func TestGetMetricByNameFiltersByMetricSelector(t *testing.T) {
+	p := newTestProvider()
+
+	info := provider.CustomMetricInfo{
+		GroupResource: schema.GroupResource{Resource: "pods"},
+		Metric:        "http_requests",
+		Namespaced:    true,
+	}
+
+	seed := func(code string, value int64) {
+		resource := CustomMetricResource{
+			CustomMetricInfo: info,
+			Name:             "my-pod",
+			Namespace:        "default",
+			Labels:           labels.Set{"code": code}.String(),
+		}
+		buffer := newSampleBuffer(defaultHistoryCapacity)
+		buffer.overwriteLast(metricSample{Value: value, Timestamp: time.Now()})
+		p.values[resource] = &seriesEntry{key: resource, buffer: buffer, lastUpdated: time.Now()}
+	}
+	seed("200", 1)
+	seed("500", 99)
+
+	name := types.NamespacedName{Namespace: "default", Name: "my-pod"}
+
+	selector500, err := labels.Parse("code=500")
+	if err != nil {
+		t.Fatalf("unable to parse test selector: %v", err)
+	}
+	metric, err := p.GetMetricByName(name, info, selector500)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := metric.Value.Value(); got != 99 {
+		t.Fatalf("expected the code=500 series' value 99, got %d", got)
+	}
+
+	selectorNone, err := labels.Parse("code=404")
+	if err != nil {
+		t.Fatalf("unable to parse test selector: %v", err)
+	}
+	if _, err := p.GetMetricByName(name, info, selectorNone); err == nil {
+		t.Fatal("expected a not-found error when no series matches the metricSelector")
+	}
+}
+
+func TestEvictExpiredRemovesStaleSeries(t *testing.T) {
+	p := &E2EProvider{
+		historyCapacity: defaultHistoryCapacity,
+		values:          make(map[CustomMetricResource]*seriesEntry),
+		externalValues:  make(map[ExternalMetricResource]*externalSeriesEntry),
+	}
+
+	stale := CustomMetricResource{Name: "stale-pod"}
+	fresh := CustomMetricResource{Name: "fresh-pod"}
+
+	now := time.Now()
+	staleEntry := &seriesEntry{key: stale, buffer: newSampleBuffer(1), lastUpdated: now.Add(-time.Hour)}
+	freshEntry := &seriesEntry{key: fresh, buffer: newSampleBuffer(1), lastUpdated: now}
+	p.values[stale] = staleEntry
+	p.values[fresh] = freshEntry
+	heap.Push(&p.gcHeap, staleEntry)
+	heap.Push(&p.gcHeap, freshEntry)
+
+	p.evictExpired(10 * time.Minute)
+
+	if _, ok := p.values[stale]; ok {
+		t.Fatal("expected the stale series to be evicted")
+	}
+	if _, ok := p.values[fresh]; !ok {
+		t.Fatal("expected the fresh series to survive eviction")
+	}
+	if p.evictions != 1 {
+		t.Fatalf("expected exactly one eviction to be counted, got %d", p.evictions)
+	}
+}